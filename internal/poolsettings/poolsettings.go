@@ -0,0 +1,81 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package poolsettings holds the connection pool tuning shared by the
+// sqlite and postgresql adapters, so the two don't carry duplicate copies
+// of the same type and backoff logic.
+package poolsettings
+
+import (
+	"database/sql"
+	"time"
+
+	"upper.io/db.v2"
+)
+
+// Settings is implemented by db.ConnectionURL values that carry optional
+// connection pool tuning, such as the adapters' own WithPoolSettings.
+type Settings interface {
+	MaxOpenConns() int
+	MaxIdleConns() int
+	ConnMaxLifetime() time.Duration
+}
+
+// Apply configures sess's pool limits from connURL's Settings, if it
+// implements one. defaultMaxOpenConns is used in place of MaxOpenConns()
+// when connURL carries no Settings, or when it reports zero (meaning "use
+// the adapter's default"); pass 0 to leave database/sql's own unlimited
+// default in place. MaxIdleConns and ConnMaxLifetime are left at
+// database/sql's own defaults unless explicitly set to a positive value,
+// so tuning only MaxOpenConns doesn't also zero out idle-connection
+// retention.
+func Apply(sess *sql.DB, connURL db.ConnectionURL, defaultMaxOpenConns int) {
+	maxOpenConns := defaultMaxOpenConns
+
+	if settings, ok := connURL.(Settings); ok {
+		if settings.MaxOpenConns() > 0 {
+			maxOpenConns = settings.MaxOpenConns()
+		}
+		if settings.MaxIdleConns() > 0 {
+			sess.SetMaxIdleConns(settings.MaxIdleConns())
+		}
+		if settings.ConnMaxLifetime() > 0 {
+			sess.SetConnMaxLifetime(settings.ConnMaxLifetime())
+		}
+	}
+
+	if maxOpenConns > 0 {
+		sess.SetMaxOpenConns(maxOpenConns)
+	}
+}
+
+// BackoffDuration returns the delay before the (attempt+1)-th retry of a
+// connection that failed because too many clients were already connected.
+func BackoffDuration(attempt int) time.Duration {
+	d := 50 * time.Millisecond
+	for i := 0; i < attempt; i++ {
+		d *= 2
+	}
+	if d > 5*time.Second {
+		return 5 * time.Second
+	}
+	return d
+}
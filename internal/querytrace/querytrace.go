@@ -0,0 +1,212 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package querytrace wraps a database/sql/driver.Driver so every statement
+// it actually runs against the server is reported through logger.Log with
+// real timing, arguments, rows affected and error -- unlike instrumenting
+// around statement compilation, which only measures how long it took to
+// build the SQL text.
+package querytrace
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"upper.io/db.v2/logger"
+)
+
+// WrapDriver returns a driver.Driver that behaves exactly like d, except
+// that every Exec and Query it runs is timed and reported through
+// logger.Log.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return &tracedDriver{Driver: d}
+}
+
+type tracedDriver struct {
+	driver.Driver
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn}, nil
+}
+
+// tracedConn wraps a driver.Conn, adding tracing to whichever optional
+// fast-path interfaces (Execer/ExecerContext/Queryer/QueryerContext) the
+// wrapped connection implements, in addition to the Prepare path every
+// driver.Conn must support.
+type tracedConn struct {
+	driver.Conn
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if connCtx, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err := connCtx.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return &tracedStmt{Stmt: stmt, query: query}, nil
+	}
+	return c.Prepare(query)
+}
+
+func (c *tracedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.Exec(query, args)
+	report(query, valuesToArgs(args), start, result, err)
+	return result, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	report(query, namedValuesToArgs(args), start, result, err)
+	return result, err
+}
+
+func (c *tracedConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.Query(query, args)
+	report(query, valuesToArgs(args), start, nil, err)
+	return rows, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	report(query, namedValuesToArgs(args), start, nil, err)
+	return rows, err
+}
+
+// tracedStmt wraps a prepared driver.Stmt, tracing Exec/Query the same way
+// tracedConn does for the unprepared fast path.
+type tracedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	result, err := s.Stmt.Exec(args)
+	report(s.query, valuesToArgs(args), start, result, err)
+	return result, err
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		// database/sql does not fall back on ErrSkip from a Stmt, so run
+		// the plain Exec path (which is traced too).
+		values := make([]driver.Value, len(args))
+		for i, a := range args {
+			values[i] = a.Value
+		}
+		return s.Exec(values)
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, args)
+	report(s.query, namedValuesToArgs(args), start, result, err)
+	return result, err
+}
+
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.Stmt.Query(args)
+	report(s.query, valuesToArgs(args), start, nil, err)
+	return rows, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		values := make([]driver.Value, len(args))
+		for i, a := range args {
+			values[i] = a.Value
+		}
+		return s.Query(values)
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	report(s.query, namedValuesToArgs(args), start, nil, err)
+	return rows, err
+}
+
+// report sends a logger.QueryStatus for a finished statement. result is nil
+// for queries, since driver.Rows carries no affected-row count.
+func report(query string, args []interface{}, start time.Time, result driver.Result, err error) {
+	status := logger.QueryStatus{
+		Query: query,
+		Args:  args,
+		Start: start,
+		End:   time.Now(),
+		Err:   err,
+	}
+	if result != nil {
+		if n, rerr := result.RowsAffected(); rerr == nil {
+			status.RowsAffected = n
+		}
+	}
+	logger.Log(status)
+}
+
+func valuesToArgs(values []driver.Value) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func namedValuesToArgs(values []driver.NamedValue) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v.Value
+	}
+	return args
+}
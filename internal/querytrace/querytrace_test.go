@@ -0,0 +1,109 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package querytrace
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"upper.io/db.v2/logger"
+)
+
+// fakeDriver/fakeConn/fakeResult implement just enough of database/sql/driver
+// to exercise tracedConn's Execer/Queryer fast path without a real database.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                  { return nil, errors.New("not implemented") }
+
+func (fakeConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return fakeResult{rowsAffected: int64(len(args))}, nil
+}
+
+func (fakeConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+type fakeResult struct {
+	rowsAffected int64
+}
+
+func (fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+type recordingLogger struct {
+	statuses []logger.QueryStatus
+}
+
+func (r *recordingLogger) LogQuery(status logger.QueryStatus) {
+	r.statuses = append(r.statuses, status)
+}
+
+func TestWrapDriverTracesExec(t *testing.T) {
+	rec := &recordingLogger{}
+	logger.SetLogger(rec)
+	defer logger.SetLogger(nil)
+
+	wrapped := WrapDriver(fakeDriver{})
+	conn, err := wrapped.Open("irrelevant")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	execer, ok := conn.(driver.Execer)
+	if !ok {
+		t.Fatalf("wrapped conn does not implement driver.Execer")
+	}
+
+	if _, err := execer.Exec("UPDATE t SET a = ?", []driver.Value{1, 2}); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+
+	if len(rec.statuses) != 1 {
+		t.Fatalf("expected 1 logged status, got %d", len(rec.statuses))
+	}
+
+	got := rec.statuses[0]
+	if got.Query != "UPDATE t SET a = ?" {
+		t.Errorf("Query = %q, want %q", got.Query, "UPDATE t SET a = ?")
+	}
+	if got.RowsAffected != 2 {
+		t.Errorf("RowsAffected = %d, want 2", got.RowsAffected)
+	}
+	if got.Err != nil {
+		t.Errorf("Err = %v, want nil", got.Err)
+	}
+	if got.Duration() < 0 || got.Start.After(time.Now()) {
+		t.Errorf("unexpected Start/Duration: %+v", got)
+	}
+}
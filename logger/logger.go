@@ -0,0 +1,114 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package logger lets adapters report the statements they run through a
+// pluggable Logger, so callers can wire up tracing or slow-query alerts
+// without patching the adapters themselves. It is a regular, importable
+// package (not internal) since external callers are expected to call
+// SetLogger and implement Logger themselves.
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// QueryStatus carries everything worth knowing about a single statement:
+// its SQL text, arguments, how long it took, how many rows it touched and
+// whether it failed.
+type QueryStatus struct {
+	Query        string
+	Args         []interface{}
+	Start        time.Time
+	End          time.Time
+	RowsAffected int64
+	Err          error
+}
+
+// Duration reports how long the statement took.
+func (q QueryStatus) Duration() time.Duration {
+	return q.End.Sub(q.Start)
+}
+
+// Logger receives a QueryStatus after every statement runs. Implementations
+// must be safe for concurrent use.
+type Logger interface {
+	LogQuery(QueryStatus)
+}
+
+var (
+	mu                 sync.RWMutex
+	activeLogger       Logger = stderrLogger{}
+	slowQueryThreshold time.Duration
+)
+
+// SetLogger replaces the active logger. Passing nil restores the default
+// logger, which prints statements meeting or exceeding the slow query
+// threshold to stderr.
+func SetLogger(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l == nil {
+		l = stderrLogger{}
+	}
+	activeLogger = l
+}
+
+// SetSlowQueryThreshold sets the minimum duration a statement must take
+// before the default stderr logger reports it. A zero threshold (the
+// default) logs every statement. Custom Logger implementations are free to
+// ignore this setting.
+func SetSlowQueryThreshold(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	slowQueryThreshold = d
+}
+
+// Log reports status through the active logger.
+func Log(status QueryStatus) {
+	mu.RLock()
+	l := activeLogger
+	mu.RUnlock()
+	l.LogQuery(status)
+}
+
+// stderrLogger is the default Logger, printing only statements that meet
+// or exceed the configured slow query threshold.
+type stderrLogger struct{}
+
+func (stderrLogger) LogQuery(status QueryStatus) {
+	mu.RLock()
+	threshold := slowQueryThreshold
+	mu.RUnlock()
+
+	if status.Duration() < threshold {
+		return
+	}
+
+	if status.Err != nil {
+		fmt.Fprintf(os.Stderr, "upper: %q (%s) failed: %v\n", status.Query, status.Duration(), status.Err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "upper: %q (%s)\n", status.Query, status.Duration())
+}
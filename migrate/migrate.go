@@ -0,0 +1,524 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package migrate provides a database-agnostic schema migrations runner that
+// adapters expose through a `Migrator() *Migrator` method.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"upper.io/db.v2"
+	"upper.io/db.v2/builder/sqlgen"
+)
+
+// DefaultTableName is the name of the table used to keep track of applied
+// migrations when none is given via SetTableName.
+const DefaultTableName = `schema_migrations`
+
+var (
+	// ErrNoChange is returned by Up, Down, Steps and Migrate when there is
+	// nothing left to do.
+	ErrNoChange = errors.New(`upper: no migrations to run`)
+
+	// ErrDirty is returned when the migrations table reports a dirty state,
+	// meaning a previous run was interrupted mid-migration.
+	ErrDirty = errors.New(`upper: database is in a dirty migration state, use Force() to recover`)
+
+	// ErrVersionNotFound is returned by Migrate when the requested version is
+	// not registered.
+	ErrVersionNotFound = errors.New(`upper: migration version not found`)
+)
+
+// Migration represents a single migration step, identified by a
+// monotonically increasing Version.
+type Migration struct {
+	Version     uint64
+	Description string
+
+	UpSQL   string
+	DownSQL string
+
+	UpFn   func(db.Tx) error
+	DownFn func(db.Tx) error
+}
+
+// Locker lets an adapter serialize concurrent migration runs by handing
+// back a single transaction that the whole batch of migrations then
+// executes within, e.g. one holding a pg_advisory_xact_lock on PostgreSQL
+// or a BEGIN IMMEDIATE on SQLite. The lock is released automatically when
+// that transaction commits or rolls back, so there is no separate Unlock
+// step, and the migrations it guards can never end up running on a
+// different connection than the lock itself.
+type Locker interface {
+	Lock(sess db.Database) (db.Tx, error)
+}
+
+// Migrator loads, tracks and applies migrations against a db.Database.
+type Migrator struct {
+	sess      db.Database
+	locker    Locker
+	tableName string
+
+	migrations []Migration
+}
+
+// New creates a Migrator bound to the given session. locker may be nil if
+// the adapter has no locking primitive to offer.
+func New(sess db.Database, locker Locker) *Migrator {
+	return &Migrator{
+		sess:      sess,
+		locker:    locker,
+		tableName: DefaultTableName,
+	}
+}
+
+// SetTableName overrides the name of the table used to track applied
+// migrations. Must be called before any other Migrator method.
+func (m *Migrator) SetTableName(name string) {
+	m.tableName = name
+}
+
+// Register adds a migration that was built in code, as opposed to loaded
+// from a directory.
+func (m *Migrator) Register(mig Migration) {
+	m.migrations = append(m.migrations, mig)
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+}
+
+var sourceNameRx = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadDir reads *.up.sql and *.down.sql files out of dir and registers them
+// as migrations. Files are expected to be named
+// "<version>_<description>.(up|down).sql", mirroring the convention used by
+// go-bindata-style embedded migration sources.
+func (m *Migrator) LoadDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	byVersion := map[uint64]*Migration{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := sourceNameRx.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("upper: invalid migration version in %q: %v", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Description: matches[2]}
+			byVersion[version] = mig
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		switch matches[3] {
+		case "up":
+			mig.UpSQL = string(contents)
+		case "down":
+			mig.DownSQL = string(contents)
+		}
+	}
+
+	for _, mig := range byVersion {
+		m.Register(*mig)
+	}
+
+	return nil
+}
+
+func (m *Migrator) ensureTable(sess db.Database) error {
+	stmt := sqlgen.RawSQL(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, dirty BOOLEAN NOT NULL DEFAULT false)`,
+		m.tableName,
+	))
+	_, err := sess.Builder().Exec(stmt)
+	return err
+}
+
+// version is Version, but run against sess rather than always m.sess, so
+// Up/Down/Steps/Migrate can read it through the session a Locker handed
+// back without racing a concurrent migrator between the read and the
+// migrations it decides to run.
+func (m *Migrator) version(sess db.Database) (version uint64, dirty bool, err error) {
+	if err = m.ensureTable(sess); err != nil {
+		return 0, false, err
+	}
+
+	row := sess.Builder().Select("version", "dirty").From(m.tableName).Iterator()
+	defer row.Close()
+
+	if !row.Next() {
+		return 0, false, row.Err()
+	}
+
+	if err = row.Scan(&version, &dirty); err != nil {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// Version returns the currently applied version and whether the table is
+// marked dirty (i.e. a previous migration was interrupted).
+func (m *Migrator) Version() (version uint64, dirty bool, err error) {
+	return m.version(m.sess)
+}
+
+// Force sets the reported version without running any migration, clearing
+// the dirty flag. Use this to recover from an interrupted run.
+func (m *Migrator) Force(version uint64) error {
+	if err := m.ensureTable(m.sess); err != nil {
+		return err
+	}
+
+	_, err := m.sess.Builder().Exec(sqlgen.RawSQL(fmt.Sprintf(`DELETE FROM %s`, m.tableName)))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.sess.Builder().InsertInto(m.tableName).
+		Values(map[string]interface{}{"version": version, "dirty": false}).
+		Exec()
+
+	return err
+}
+
+func (m *Migrator) setVersion(tx db.Tx, version uint64, dirty bool) error {
+	if _, err := tx.Builder().Exec(sqlgen.RawSQL(fmt.Sprintf(`DELETE FROM %s`, m.tableName))); err != nil {
+		return err
+	}
+
+	_, err := tx.Builder().InsertInto(m.tableName).
+		Values(map[string]interface{}{"version": version, "dirty": dirty}).
+		Exec()
+
+	return err
+}
+
+// previousVersion returns the version of the highest registered migration
+// strictly below version, or 0 if none is registered below it. It is what
+// a reverted migration's version gets rolled back to, rather than the
+// version of the migration that was just reverted.
+func (m *Migrator) previousVersion(version uint64) uint64 {
+	var prev uint64
+	for _, mig := range m.migrations {
+		if mig.Version < version && mig.Version > prev {
+			prev = mig.Version
+		}
+	}
+	return prev
+}
+
+// run applies a single migration, marking the tracking row dirty before
+// executing it and clearing the flag once it completes successfully, so an
+// interruption is detectable (and recoverable via Force) instead of
+// silently half-applied. When locked is non-nil (see withLock), it runs
+// against that shared, lock-held transaction so the whole batch commits or
+// rolls back together; otherwise it opens its own transaction per
+// migration, as when no Locker was configured.
+func (m *Migrator) run(locked db.Tx, mig Migration, down bool) error {
+	tx := locked
+	ownTx := tx == nil
+	if ownTx {
+		var err error
+		if tx, err = m.sess.Transaction(); err != nil {
+			return err
+		}
+	}
+
+	fail := func(err error) error {
+		if ownTx {
+			tx.Rollback()
+		}
+		return err
+	}
+
+	if err := m.setVersion(tx, mig.Version, true); err != nil {
+		return fail(err)
+	}
+
+	var err error
+	if down {
+		if mig.DownFn != nil {
+			err = mig.DownFn(tx)
+		} else if mig.DownSQL != "" {
+			_, err = tx.Builder().Exec(sqlgen.RawSQL(mig.DownSQL))
+		}
+	} else {
+		if mig.UpFn != nil {
+			err = mig.UpFn(tx)
+		} else if mig.UpSQL != "" {
+			_, err = tx.Builder().Exec(sqlgen.RawSQL(mig.UpSQL))
+		}
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+
+	version := mig.Version
+	if down {
+		version = m.previousVersion(mig.Version)
+	}
+
+	if err := m.setVersion(tx, version, false); err != nil {
+		return fail(err)
+	}
+
+	if ownTx {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// withLock acquires m.locker (if any) and invokes fn with the db.Tx it
+// returned, committing it once fn succeeds (or rolling it back if fn
+// fails) so every statement fn's migrations run, not just the lock
+// acquisition itself, is serialized against other migrators. fn receives a
+// nil db.Tx when no Locker was configured.
+func (m *Migrator) withLock(fn func(tx db.Tx) error) error {
+	if m.locker == nil {
+		return fn(nil)
+	}
+
+	tx, err := m.locker.Lock(m.sess)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sessOrTx returns tx as a db.Database when it is non-nil (i.e. the batch
+// is running under a Locker's transaction), falling back to m.sess.
+func (m *Migrator) sessOrTx(tx db.Tx) db.Database {
+	if tx != nil {
+		return tx
+	}
+	return m.sess
+}
+
+// Up applies all registered migrations with a version greater than the
+// currently applied one, in order.
+func (m *Migrator) Up() error {
+	return m.withLock(func(locked db.Tx) error {
+		version, dirty, err := m.version(m.sessOrTx(locked))
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		applied := false
+		for _, mig := range m.migrations {
+			if mig.Version <= version {
+				continue
+			}
+			if err := m.run(locked, mig, false); err != nil {
+				return err
+			}
+			applied = true
+		}
+
+		if !applied {
+			return ErrNoChange
+		}
+		return nil
+	})
+}
+
+// Down reverts all applied migrations, in reverse order.
+func (m *Migrator) Down() error {
+	return m.withLock(func(locked db.Tx) error {
+		version, dirty, err := m.version(m.sessOrTx(locked))
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		reverted := false
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if mig.Version > version {
+				continue
+			}
+			if err := m.run(locked, mig, true); err != nil {
+				return err
+			}
+			reverted = true
+		}
+
+		if !reverted {
+			return ErrNoChange
+		}
+		return nil
+	})
+}
+
+// Steps applies n migrations forward (n > 0) or reverts -n migrations
+// backward (n < 0) relative to the currently applied version.
+func (m *Migrator) Steps(n int) error {
+	if n == 0 {
+		return ErrNoChange
+	}
+
+	return m.withLock(func(locked db.Tx) error {
+		version, dirty, err := m.version(m.sessOrTx(locked))
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		if n > 0 {
+			count := 0
+			for _, mig := range m.migrations {
+				if count >= n {
+					break
+				}
+				if mig.Version <= version {
+					continue
+				}
+				if err := m.run(locked, mig, false); err != nil {
+					return err
+				}
+				count++
+			}
+			if count == 0 {
+				return ErrNoChange
+			}
+			return nil
+		}
+
+		count := 0
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if count >= -n {
+				break
+			}
+			mig := m.migrations[i]
+			if mig.Version > version {
+				continue
+			}
+			if err := m.run(locked, mig, true); err != nil {
+				return err
+			}
+			count++
+		}
+		if count == 0 {
+			return ErrNoChange
+		}
+		return nil
+	})
+}
+
+// versionIsValidTarget reports whether targetVersion is a valid argument to
+// Migrate: either the sentinel 0 (revert everything) or the Version of some
+// registered migration. 0 is always valid even though no migration is ever
+// registered with Version 0 (doing so would make that migration
+// indistinguishable from the unapplied state and get silently skipped).
+func (m *Migrator) versionIsValidTarget(targetVersion uint64) bool {
+	if targetVersion == 0 {
+		return true
+	}
+	for _, mig := range m.migrations {
+		if mig.Version == targetVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrate brings the database to the exact targetVersion, applying or
+// reverting migrations as needed.
+func (m *Migrator) Migrate(targetVersion uint64) error {
+	if !m.versionIsValidTarget(targetVersion) {
+		return ErrVersionNotFound
+	}
+
+	return m.withLock(func(locked db.Tx) error {
+		version, dirty, err := m.version(m.sessOrTx(locked))
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		if targetVersion == version {
+			return ErrNoChange
+		}
+
+		if targetVersion > version {
+			for _, mig := range m.migrations {
+				if mig.Version <= version || mig.Version > targetVersion {
+					continue
+				}
+				if err := m.run(locked, mig, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if mig.Version > version || mig.Version <= targetVersion {
+				continue
+			}
+			if err := m.run(locked, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
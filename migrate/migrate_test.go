@@ -0,0 +1,78 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package migrate
+
+import "testing"
+
+// TestMigratorPreviousVersion covers the bug where reverting a migration
+// re-recorded its own version instead of the version below it: Down()
+// must leave the tracked version at the migration below the one it just
+// reverted (or 0 if none remains), so a subsequent Up() reapplies it
+// rather than skipping it as already-applied.
+func TestMigratorPreviousVersion(t *testing.T) {
+	m := &Migrator{}
+	m.Register(Migration{Version: 10})
+	m.Register(Migration{Version: 20})
+	m.Register(Migration{Version: 30})
+
+	cases := []struct {
+		version uint64
+		want    uint64
+	}{
+		{version: 30, want: 20},
+		{version: 20, want: 10},
+		{version: 10, want: 0},
+		{version: 5, want: 0},
+	}
+
+	for _, c := range cases {
+		if got := m.previousVersion(c.version); got != c.want {
+			t.Errorf("previousVersion(%d) = %d, want %d", c.version, got, c.want)
+		}
+	}
+}
+
+// TestMigratorVersionIsValidTarget covers the bug where Migrate(0) ("revert
+// everything") always failed with ErrVersionNotFound because 0 never
+// matches a registered migration's Version -- 0 is this package's sentinel
+// for "nothing applied" and must be accepted without a matching migration.
+func TestMigratorVersionIsValidTarget(t *testing.T) {
+	m := &Migrator{}
+	m.Register(Migration{Version: 10})
+	m.Register(Migration{Version: 20})
+
+	cases := []struct {
+		target uint64
+		want   bool
+	}{
+		{target: 0, want: true},
+		{target: 10, want: true},
+		{target: 20, want: true},
+		{target: 15, want: false},
+	}
+
+	for _, c := range cases {
+		if got := m.versionIsValidTarget(c.target); got != c.want {
+			t.Errorf("versionIsValidTarget(%d) = %v, want %v", c.target, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/lib/pq"
+)
+
+// BulkInserter streams records into a table using PostgreSQL's `COPY FROM
+// STDIN` protocol, which is an order of magnitude faster than batched
+// INSERTs for large imports.
+type BulkInserter interface {
+	// Append queues record for insertion, inferring the column list from its
+	// `db` struct tags on the first call.
+	Append(record interface{}) error
+
+	// Flush sends any queued records down the COPY stream.
+	Flush() error
+
+	// Close flushes pending records and ends the underlying transaction.
+	Close() error
+}
+
+type bulkInserter struct {
+	ctx       context.Context
+	tableName string
+	tx        *sql.Tx
+	stmt      *sql.Stmt
+	columns   []string
+}
+
+// BulkInsert opens a COPY FROM STDIN stream for the given table over a new
+// transaction.
+func (d *database) BulkInsert(tableName string) (BulkInserter, error) {
+	return d.BulkInsertContext(d.context(), tableName)
+}
+
+// BulkInsertContext is like BulkInsert but ties the COPY stream to ctx, so
+// cancelling ctx aborts it instead of leaving it to run to completion.
+func (d *database) BulkInsertContext(ctx context.Context, tableName string) (BulkInserter, error) {
+	tx, err := d.Session().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &bulkInserter{ctx: ctx, tableName: tableName, tx: tx}, nil
+}
+
+func columnsFromTags(v reflect.Value) []string {
+	t := v.Type()
+	columns := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("db")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		columns = append(columns, tag)
+	}
+	return columns
+}
+
+func (b *bulkInserter) Append(record interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("upper: BulkInserter.Append expects a struct, got %T", record)
+	}
+
+	if b.stmt == nil {
+		b.columns = columnsFromTags(v)
+		stmt, err := b.tx.PrepareContext(b.ctx, pq.CopyIn(b.tableName, b.columns...))
+		if err != nil {
+			return err
+		}
+		b.stmt = stmt
+	}
+
+	byColumn := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("db"); tag != "" && tag != "-" {
+			byColumn[tag] = v.Field(i).Interface()
+		}
+	}
+
+	values := make([]interface{}, len(b.columns))
+	for i, column := range b.columns {
+		values[i] = byColumn[column]
+	}
+
+	_, err := b.stmt.ExecContext(b.ctx, values...)
+	return err
+}
+
+// Flush completes the COPY stream, sending any staged rows to the server.
+func (b *bulkInserter) Flush() error {
+	if b.stmt == nil {
+		return nil
+	}
+	if _, err := b.stmt.ExecContext(b.ctx); err != nil {
+		return err
+	}
+	err := b.stmt.Close()
+	b.stmt = nil
+	return err
+}
+
+// Close flushes any pending rows and commits the underlying transaction.
+func (b *bulkInserter) Close() error {
+	if err := b.Flush(); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return b.tx.Commit()
+}
@@ -22,26 +22,109 @@
 package postgresql
 
 import (
+	"context"
 	"strconv"
 	"strings"
+	"time"
 
 	"database/sql"
 
-	_ "github.com/lib/pq" // PostgreSQL driver.
+	"github.com/lib/pq"
 	"upper.io/db.v2"
 	"upper.io/db.v2/builder/sqlgen"
+	"upper.io/db.v2/internal/poolsettings"
+	"upper.io/db.v2/internal/querytrace"
 	"upper.io/db.v2/internal/sqladapter"
 )
 
+// tracedDriverName is registered against a querytrace-wrapped pq.Driver, so
+// Exec/Query timing, arguments, rows affected and errors reported through
+// logger.Log reflect the real round-trip to PostgreSQL rather than the time
+// spent compiling a statement's SQL text.
+const tracedDriverName = "postgres-traced"
+
+func init() {
+	sql.Register(tracedDriverName, querytrace.WrapDriver(&pq.Driver{}))
+}
+
 type database struct {
 	*sqladapter.BaseDatabase
+	ctx context.Context
 }
 
 var _ = db.Database(&database{})
 
-// CompileAndReplacePlaceholders compiles the given statement into an string
-// and replaces each generic placeholder with the placeholder the driver
-// expects (if any).
+const maxBackoffRetries = 8
+
+// WithPoolSettings wraps a db.ConnectionURL, adding the pool tuning options
+// applyPoolSettings looks for: MaxOpenConns, MaxIdleConns and
+// ConnMaxLifetime. Wrap an existing db.ConnectionURL value with it instead
+// of changing that value's own type:
+//
+//	sess, err := db.Open(postgresql.Adapter, postgresql.WithPoolSettings{
+//		ConnectionURL:        connURL,
+//		MaxOpenConnsValue:    20,
+//		MaxIdleConnsValue:    5,
+//		ConnMaxLifetimeValue: time.Hour,
+//	})
+type WithPoolSettings struct {
+	db.ConnectionURL
+	MaxOpenConnsValue    int
+	MaxIdleConnsValue    int
+	ConnMaxLifetimeValue time.Duration
+}
+
+// MaxOpenConns returns the configured maximum number of open connections.
+func (w WithPoolSettings) MaxOpenConns() int { return w.MaxOpenConnsValue }
+
+// MaxIdleConns returns the configured maximum number of idle connections.
+func (w WithPoolSettings) MaxIdleConns() int { return w.MaxIdleConnsValue }
+
+// ConnMaxLifetime returns the configured maximum connection lifetime.
+func (w WithPoolSettings) ConnMaxLifetime() time.Duration { return w.ConnMaxLifetimeValue }
+
+// quoteIdentifier safely quotes a possibly schema-qualified identifier
+// (`schema.table`) using pq.QuoteIdentifier on each unquoted, dot-separated
+// part, so callers never have to string-concatenate raw names into SQL.
+func quoteIdentifier(name string) string {
+	parts := splitUnquotedDot(name)
+	for i := range parts {
+		parts[i] = pq.QuoteIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// splitUnquotedDot splits name on '.' characters that are not inside a
+// pair of double quotes, trimming any quotes from each resulting part.
+func splitUnquotedDot(name string) []string {
+	var parts []string
+	var buf strings.Builder
+	inQuotes := false
+
+	for _, r := range name {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == '.' && !inQuotes:
+			parts = append(parts, buf.String())
+			buf.Reset()
+			continue
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	parts = append(parts, buf.String())
+
+	return parts
+}
+
+func applyPoolSettings(sess *sql.DB, connURL db.ConnectionURL) {
+	poolsettings.Apply(sess, connURL, 0)
+}
+
+// CompileAndReplacePlaceholders compiles the given statement into a string
+// and replaces each generic placeholder with the numbered placeholder
+// PostgreSQL expects ($1, $2, ...).
 func (d *database) CompileAndReplacePlaceholders(stmt *sqlgen.Statement) (query string) {
 	buf := stmt.Compile(d.Template())
 
@@ -73,7 +156,7 @@ func (d *database) open() error {
 	var sess *sql.DB
 
 	connFn := func(sess **sql.DB) (err error) {
-		*sess, err = sql.Open("postgres", d.ConnectionURL().String())
+		*sess, err = sql.Open(tracedDriverName, d.ConnectionURL().String())
 		return
 	}
 
@@ -81,9 +164,25 @@ func (d *database) open() error {
 		return err
 	}
 
+	applyPoolSettings(sess, d.ConnectionURL())
+
 	return d.Bind(sess)
 }
 
+// openWithBackoff retries open() with exponential backoff as long as the
+// failure maps to db.ErrTooManyClients, since that condition tends to clear
+// once other sessions close their connections.
+func (d *database) openWithBackoff() error {
+	var err error
+	for attempt := 0; attempt < maxBackoffRetries; attempt++ {
+		if err = d.open(); err == nil || d.Err(err) != db.ErrTooManyClients {
+			return err
+		}
+		time.Sleep(poolsettings.BackoffDuration(attempt))
+	}
+	return err
+}
+
 // Open attempts to open a connection to the database server.
 func (d *database) Open(connURL db.ConnectionURL) error {
 	if connURL == nil {
@@ -92,7 +191,7 @@ func (d *database) Open(connURL db.ConnectionURL) error {
 
 	d.BaseDatabase = sqladapter.NewDatabase(d, connURL, template())
 
-	return d.open()
+	return d.openWithBackoff()
 }
 
 // Clone creates a new database connection with the same settings as the
@@ -126,9 +225,37 @@ func (d *database) Collections() (collections []string, err error) {
 	return collections, nil
 }
 
+// WithContext returns a shallow copy of d whose default context is ctx, so
+// Transaction and BulkInsert use it instead of context.Background(). Full
+// context support for non-transactional Builder/Selector/Iterator queries
+// would need WithContext plumbed into the external builder package, which
+// isn't part of this chunk's snapshot.
+func (d *database) WithContext(ctx context.Context) db.Database {
+	clone := *d
+	clone.ctx = ctx
+	return &clone
+}
+
+// context returns d's default context, falling back to context.Background
+// when WithContext was never called.
+func (d *database) context() context.Context {
+	if d.ctx != nil {
+		return d.ctx
+	}
+	return context.Background()
+}
+
 // Transaction starts a transaction block and returns a db.Tx struct that can
 // be used to issue transactional queries.
 func (d *database) Transaction() (db.Tx, error) {
+	return d.TransactionContext(d.context(), nil)
+}
+
+// TransactionContext is like Transaction but ties the transaction to ctx and
+// lets the caller request a specific isolation level via opts (PostgreSQL
+// additionally supports sql.LevelRepeatableRead and sql.LevelSerializable),
+// so a cancelled or timed-out ctx aborts the in-flight transaction.
+func (d *database) TransactionContext(ctx context.Context, opts *sql.TxOptions) (db.Tx, error) {
 	var err error
 	var sqlTx *sql.Tx
 	var clone *database
@@ -138,7 +265,7 @@ func (d *database) Transaction() (db.Tx, error) {
 	}
 
 	connFn := func(sqlTx **sql.Tx) (err error) {
-		*sqlTx, err = clone.Session().Begin()
+		*sqlTx, err = clone.Session().BeginTx(ctx, opts)
 		return
 	}
 
@@ -203,7 +330,7 @@ func (d *database) TablePrimaryKey(tableName string) ([]string, error) {
 	q := d.Builder().Select("pg_attribute.attname AS pkey").
 		From("pg_index", "pg_class", "pg_attribute").
 		Where(`
-			pg_class.oid = '"` + tableName + `"'::regclass
+			pg_class.oid = `+pq.QuoteLiteral(quoteIdentifier(tableName))+`::regclass
 			AND indrelid = pg_class.oid
 			AND pg_attribute.attrelid = pg_class.oid
 			AND pg_attribute.attnum = ANY(pg_index.indkey)
@@ -229,7 +356,7 @@ func (d *database) TablePrimaryKey(tableName string) ([]string, error) {
 func (d *database) clone() (*database, error) {
 	clone := &database{}
 	clone.BaseDatabase = d.BaseDatabase.Clone(clone)
-	if err := clone.open(); err != nil {
+	if err := clone.openWithBackoff(); err != nil {
 		return nil, err
 	}
 	return clone, nil
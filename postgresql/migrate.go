@@ -0,0 +1,64 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package postgresql
+
+import (
+	"fmt"
+
+	"upper.io/db.v2"
+	"upper.io/db.v2/builder/sqlgen"
+	"upper.io/db.v2/migrate"
+)
+
+// advisoryLockID is an arbitrary constant used to namespace the migration
+// lock within pg_advisory_xact_lock's 64-bit keyspace.
+const advisoryLockID = 918273645
+
+// advisoryLocker serializes concurrent migration runs across processes
+// using a transaction-scoped PostgreSQL advisory lock: Lock takes the lock
+// inside a transaction and hands that same transaction back for the whole
+// batch of migrations to run in, so the lock and the work it guards always
+// share one connection. pg_advisory_xact_lock releases itself when the
+// transaction commits or rolls back, so there is no separate unlock step
+// (unlike the session-scoped pg_advisory_lock/pg_advisory_unlock, which can
+// end up split across two different pooled connections).
+type advisoryLocker struct{}
+
+func (advisoryLocker) Lock(sess db.Database) (db.Tx, error) {
+	tx, err := sess.Transaction()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Builder().Exec(sqlgen.RawSQL(fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", advisoryLockID))); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Migrator returns a migrate.Migrator bound to this database, serializing
+// concurrent runs via pg_advisory_xact_lock.
+func (d *database) Migrator() *migrate.Migrator {
+	return migrate.New(d, advisoryLocker{})
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sqlite
+
+import (
+	"context"
+
+	"upper.io/db.v2"
+)
+
+// defaultBulkInsertBatchSize is the number of rows grouped into a single
+// multi-row INSERT statement.
+const defaultBulkInsertBatchSize = 500
+
+// BulkInserter streams records into a table in batches of multi-row INSERT
+// statements run inside a single transaction, giving callers the same
+// portable API as the postgresql adapter's COPY-based implementation.
+type BulkInserter interface {
+	Append(record interface{}) error
+	Flush() error
+	Close() error
+}
+
+type bulkInserter struct {
+	ctx       context.Context
+	tableName string
+	batchSize int
+	tx        db.Tx
+	pending   []interface{}
+}
+
+// BulkInsert opens a batched bulk insert session for the given table.
+func (d *database) BulkInsert(tableName string) (BulkInserter, error) {
+	return d.BulkInsertContext(d.context(), tableName)
+}
+
+// BulkInsertContext is like BulkInsert but ctx is checked between batches so
+// a cancellation stops the stream instead of queuing further INSERTs.
+func (d *database) BulkInsertContext(ctx context.Context, tableName string) (BulkInserter, error) {
+	tx, err := d.TransactionContext(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bulkInserter{
+		ctx:       ctx,
+		tableName: tableName,
+		batchSize: defaultBulkInsertBatchSize,
+		tx:        tx,
+	}, nil
+}
+
+func (b *bulkInserter) Append(record interface{}) error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+
+	b.pending = append(b.pending, record)
+	if len(b.pending) >= b.batchSize {
+		return b.flushPending()
+	}
+	return nil
+}
+
+func (b *bulkInserter) flushPending() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+
+	_, err := b.tx.Builder().InsertInto(b.tableName).Values(b.pending...).Exec()
+	b.pending = b.pending[:0]
+	return err
+}
+
+// Flush sends any queued records down as a multi-row INSERT.
+func (b *bulkInserter) Flush() error {
+	return b.flushPending()
+}
+
+// Close flushes any pending rows and commits the underlying transaction.
+func (b *bulkInserter) Close() error {
+	if err := b.flushPending(); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	return b.tx.Commit()
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sqlite
+
+import (
+	"upper.io/db.v2"
+	"upper.io/db.v2/migrate"
+)
+
+// fileLocker serializes migration runs against a single SQLite file by
+// handing the whole batch a single write transaction, since SQLite has no
+// advisory lock primitive of its own. Open enables _txlock=immediate on
+// the adapter's DSN, so the BEGIN behind this transaction acquires
+// SQLite's write lock immediately instead of deferring it until the first
+// write; that immediate acquisition, combined with every migration in the
+// batch running on this one transaction instead of one of its own, is what
+// makes concurrent migrators block here rather than race into SQLITE_BUSY
+// later.
+type fileLocker struct{}
+
+func (fileLocker) Lock(sess db.Database) (db.Tx, error) {
+	return sess.Transaction()
+}
+
+// Migrator returns a migrate.Migrator bound to this database, serializing
+// concurrent runs via a single BEGIN IMMEDIATE transaction.
+func (d *database) Migrator() *migrate.Migrator {
+	return migrate.New(d, fileLocker{})
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2012-2015 The upper.io/db authors. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sqlite
+
+import "testing"
+
+func TestQuoteIdentifier(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{name: "mytable", want: "[mytable]"},
+		{name: "main.mytable", want: "[main].[mytable]"},
+		{name: "weird]name", want: "[weird]]name]"},
+	}
+
+	for _, c := range cases {
+		if got := quoteIdentifier(c.name); got != c.want {
+			t.Errorf("quoteIdentifier(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPragmaSchemaPrefix(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{name: "mytable", want: ""},
+		{name: "main.mytable", want: "[main]."},
+	}
+
+	for _, c := range cases {
+		if got := pragmaSchemaPrefix(c.name); got != c.want {
+			t.Errorf("pragmaSchemaPrefix(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUnqualifiedName(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{name: "mytable", want: "mytable"},
+		{name: "main.mytable", want: "mytable"},
+	}
+
+	for _, c := range cases {
+		if got := unqualifiedName(c.name); got != c.want {
+			t.Errorf("unqualifiedName(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestTablePrimaryKeyPragma(t *testing.T) {
+	cases := []struct {
+		tableName string
+		want      string
+	}{
+		{tableName: "mytable", want: "PRAGMA TABLE_INFO([mytable])"},
+		{tableName: "main.mytable", want: "PRAGMA [main].TABLE_INFO([mytable])"},
+	}
+
+	for _, c := range cases {
+		got := "PRAGMA " + pragmaSchemaPrefix(c.tableName) + "TABLE_INFO(" + quoteIdentifier(unqualifiedName(c.tableName)) + ")"
+		if got != c.want {
+			t.Errorf("PRAGMA for %q = %q, want %q", c.tableName, got, c.want)
+		}
+	}
+}